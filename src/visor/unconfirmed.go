@@ -1,29 +1,82 @@
 package visor
 
 import (
+    "container/heap"
+    "encoding/gob"
     "errors"
     "github.com/skycoin/skycoin/src/coin"
     "github.com/skycoin/skycoin/src/util"
+    "os"
+    "path/filepath"
+    "sync"
     "time"
 )
 
+// RelayPolicy bundles the tunable parameters VerifyTransactionPolicy
+// enforces, so mempool admission (RecordTxn) and network relay can share a
+// single configured policy object instead of passing its fields around
+// individually.
+type RelayPolicy struct {
+    MaxSize    int
+    BurnFactor uint64
+    // MinRelayFeePerKB is the minimum fee per 1000-byte step of txn size
+    // required to relay/admit a txn: a txn must pay (1 + size/1000) times
+    // this, so a txn of exactly 1000 bytes pays twice it. 0 disables the
+    // check.
+    MinRelayFeePerKB uint64
+    // FreeRelaySize is the txn size, in bytes, below which MinRelayFeePerKB
+    // is not enforced. 0 disables the carve-out.
+    FreeRelaySize int
+}
+
 // Performs additional transaction verification at the unconfirmed pool level.
 // This checks tunable parameters that should prevent the transaction from
 // entering the blockchain, but cannot be done at the blockchain level because
 // they may be changed.
 func VerifyTransaction(bc *coin.Blockchain, t *coin.Transaction, maxSize int,
     burnFactor uint64) error {
-    if t.Size() > maxSize {
+    return VerifyTransactionPolicy(bc, t, RelayPolicy{
+        MaxSize:    maxSize,
+        BurnFactor: burnFactor,
+    })
+}
+
+// VerifyTransactionPolicy is VerifyTransaction generalized to a RelayPolicy.
+// In addition to the size and burn-factor checks, it enforces a size-scaled
+// minimum relay fee of (1 + t.Size()/1000) * policy.MinRelayFeePerKB -- fees
+// step on full-KB boundaries -- unless t.Size() qualifies for the
+// policy.FreeRelaySize carve-out.
+func VerifyTransactionPolicy(bc *coin.Blockchain, t *coin.Transaction, policy RelayPolicy) error {
+    if t.Size() > policy.MaxSize {
         return errors.New("Transaction too large")
     }
-    if fee, err := bc.TransactionFee(t); err != nil {
+    fee, err := bc.TransactionFee(t)
+    if err != nil {
         return err
-    } else if burnFactor != 0 && t.OutputHours()/burnFactor > fee {
+    }
+    if policy.BurnFactor != 0 && t.OutputHours()/policy.BurnFactor > fee {
+        return errors.New("Transaction fee minimum not met")
+    }
+    if fee < minRelayFee(t.Size(), policy) {
         return errors.New("Transaction fee minimum not met")
     }
     return nil
 }
 
+// minRelayFee returns the minimum fee a txn of the given size must pay
+// under policy: (1 + size/1000) * MinRelayFeePerKB, stepping on full-KB
+// boundaries. It returns 0 -- i.e. no minimum -- when MinRelayFeePerKB is
+// unset or size qualifies for the FreeRelaySize carve-out.
+func minRelayFee(size int, policy RelayPolicy) uint64 {
+    if policy.MinRelayFeePerKB == 0 {
+        return 0
+    }
+    if policy.FreeRelaySize != 0 && size < policy.FreeRelaySize {
+        return 0
+    }
+    return (1 + uint64(size)/1000) * policy.MinRelayFeePerKB
+}
+
 // Maps from coin.Transaction hash to its expected unspents.  The unspents'
 // Head can be different at execution time, but the Unspent's hash is fixed.
 type TxnUnspents map[coin.SHA256]coin.UxArray
@@ -56,6 +109,149 @@ func (self *UnconfirmedTxn) Hash() coin.SHA256 {
     return self.Txn.Hash()
 }
 
+// Returns the txn's fee per byte. Used to rank txns for eviction when the
+// pool is over capacity.
+func (self *UnconfirmedTxn) feeRate(bc *coin.Blockchain) float64 {
+    size := self.Txn.Size()
+    if size == 0 {
+        return 0
+    }
+    fee, err := bc.TransactionFee(&self.Txn)
+    if err != nil {
+        return 0
+    }
+    return float64(fee) / float64(size)
+}
+
+// unconfirmedTxnsFilename is the name of the file, inside a pool's Datadir,
+// that persisted unconfirmed txns are written to.
+const unconfirmedTxnsFilename = "unconfirmed_txns.bin"
+
+// TxnEventKind identifies what kind of pool mutation a TxnEvent describes.
+type TxnEventKind byte
+
+const (
+    // TxnEventAdded means a new txn was admitted to the pool.
+    TxnEventAdded TxnEventKind = iota
+    // TxnEventRemoved means a txn was removed because it expired or was
+    // found invalid against the blockchain.
+    TxnEventRemoved
+    // TxnEventConfirmed means a txn was removed because it appeared in a
+    // confirmed block.
+    TxnEventConfirmed
+    // TxnEventEvicted means a txn was removed to make room under
+    // cfg.MaxTxns/cfg.DataCap.
+    TxnEventEvicted
+    // TxnEventReplaced means a txn was removed because a conflicting,
+    // higher-fee txn took its place via ReplaceTxn.
+    TxnEventReplaced
+)
+
+// TxnEvent describes a single mempool mutation, delivered to subscribers
+// registered with Subscribe.
+type TxnEvent struct {
+    Kind TxnEventKind
+    Hash coin.SHA256
+    Txn  coin.Transaction
+}
+
+// CancelFunc unsubscribes the channel returned by Subscribe. It is safe to
+// call more than once.
+type CancelFunc func()
+
+// defaultSubscriberBufferSize is the channel buffer size used by Subscribe
+// when cfg.SubscriberBufferSize is left at 0.
+const defaultSubscriberBufferSize = 10000
+
+// subscriber is one Subscribe() registration.
+type subscriber struct {
+    ch     chan TxnEvent
+    closed bool
+}
+
+// defaultPriceBump is the minimum percentage by which a replacement txn's
+// fee-per-byte must exceed the txn it replaces, used when
+// UnconfirmedTxnPoolConfig.PriceBump is left at 0.
+const defaultPriceBump = 10
+
+// ErrReplacementUnderpriced is returned by ReplaceTxn when a conflicting
+// txn's fee-per-byte does not exceed the incumbent's by at least
+// cfg.PriceBump percent.
+var ErrReplacementUnderpriced = errors.New("replacement transaction fee-per-byte too low")
+
+// meetsPriceBump reports whether newRate clears incumbentRate by at least
+// priceBumpPercent percent, the threshold ReplaceTxn requires before a
+// conflicting txn may replace one already in the pool.
+func meetsPriceBump(newRate, incumbentRate float64, priceBumpPercent uint64) bool {
+    return newRate >= incumbentRate*(1+float64(priceBumpPercent)/100)
+}
+
+// Configures capacity limits and on-disk persistence for an
+// UnconfirmedTxnPool. The zero value is a memory-only pool with no
+// capacity limit, equivalent to what NewUnconfirmedTxnPool returns.
+type UnconfirmedTxnPoolConfig struct {
+    // Datadir is the directory unconfirmed txns are persisted to. If
+    // empty, the pool does not touch disk and will not survive restart.
+    Datadir string
+    // DataCap is the maximum total size, in bytes, of txns held in the
+    // pool. 0 means unlimited.
+    DataCap uint64
+    // MaxTxns is the maximum number of txns held in the pool. 0 means
+    // unlimited.
+    MaxTxns int
+    // PriceBump is the minimum percentage by which a replacement txn's
+    // fee-per-byte must exceed the incumbent it double-spends, via
+    // ReplaceTxn. 0 means use defaultPriceBump.
+    PriceBump uint64
+    // SubscriberBufferSize is the per-subscriber channel buffer size used
+    // by Subscribe. 0 means use defaultSubscriberBufferSize.
+    SubscriberBufferSize int
+}
+
+// evictionItem tracks a pooled txn's position in the eviction heap.
+type evictionItem struct {
+    hash     coin.SHA256
+    feeRate  float64
+    received time.Time
+    index    int
+}
+
+// evictionHeap is a min-heap over evictionItem, ordered by ascending
+// feeRate so the lowest fee-per-byte txn is always the eviction candidate.
+// Ties are broken by oldest Received.
+type evictionHeap []*evictionItem
+
+func (h evictionHeap) Len() int { return len(h) }
+
+func (h evictionHeap) Less(i, j int) bool {
+    if h[i].feeRate != h[j].feeRate {
+        return h[i].feeRate < h[j].feeRate
+    }
+    return h[i].received.Before(h[j].received)
+}
+
+func (h evictionHeap) Swap(i, j int) {
+    h[i], h[j] = h[j], h[i]
+    h[i].index = i
+    h[j].index = j
+}
+
+func (h *evictionHeap) Push(x interface{}) {
+    item := x.(*evictionItem)
+    item.index = len(*h)
+    *h = append(*h, item)
+}
+
+func (h *evictionHeap) Pop() interface{} {
+    old := *h
+    n := len(old)
+    item := old[n-1]
+    old[n-1] = nil
+    item.index = -1
+    *h = old[:n-1]
+    return item
+}
+
 // Manages unconfirmed transactions
 type UnconfirmedTxnPool struct {
     Txns map[coin.SHA256]UnconfirmedTxn
@@ -63,12 +259,170 @@ type UnconfirmedTxnPool struct {
     // our future balance and avoid double spending our own coins
     // Maps from Transaction.Hash() to UxArray.
     Unspent TxnUnspents
+
+    cfg UnconfirmedTxnPoolConfig
+    // size is the sum of Txn.Size() across the pool, tracked incrementally
+    // so DataCap can be enforced without rescanning Txns.
+    size uint64
+    // evictHeap and evictIndex rank pooled txns by fee-per-byte so the
+    // cheapest txn can be evicted in O(log n) once the pool is full.
+    evictHeap  evictionHeap
+    evictIndex map[coin.SHA256]*evictionItem
+    // spentIndex maps a spent UxOut hash to the hash of the pool txn that
+    // spends it, so double-spends within the pool can be detected in
+    // O(1) instead of scanning every pool txn's inputs.
+    spentIndex map[coin.SHA256]coin.SHA256
+    // addrIndex maps an address to the hashes of pool txns that touch it,
+    // either as a predicted output recipient or as the owner of a spent
+    // input, so address-scoped queries don't need to scan every pool txn.
+    addrIndex map[coin.Address]map[coin.SHA256]struct{}
+    // subMu guards subscribers/nextSubscriberID. Subscribe's CancelFunc is
+    // meant to be called by a different goroutine (wallet/RPC/gossip
+    // consumer) than the one driving RecordTxn/removeTxn, so this index
+    // can't rely on the same external lock callers use to serialize pool
+    // mutations.
+    subMu sync.Mutex
+    // subscribers holds every channel registered via Subscribe, keyed by
+    // an internal id so CancelFunc can remove its own entry.
+    subscribers      map[int]*subscriber
+    nextSubscriberID int
+    // needsLoad is set when the pool was constructed with txns loaded
+    // from disk that have not yet been indexed by LoadUnconfirmed. While
+    // set, RecordTxn/ReplaceTxn refuse admission: size/Unspent/evictHeap/
+    // spentIndex/addrIndex don't yet reflect the reloaded txns, so
+    // capacity enforcement and double-spend detection would silently be
+    // disabled for them otherwise.
+    needsLoad bool
 }
 
 func NewUnconfirmedTxnPool() *UnconfirmedTxnPool {
-    return &UnconfirmedTxnPool{
-        Txns:    make(map[coin.SHA256]UnconfirmedTxn),
-        Unspent: make(TxnUnspents),
+    pool, err := NewUnconfirmedTxnPoolWithConfig(UnconfirmedTxnPoolConfig{})
+    if err != nil {
+        // A Datadir-less config never touches disk, so this cannot fail
+        panic(err)
+    }
+    return pool
+}
+
+// NewUnconfirmedTxnPoolWithConfig creates an UnconfirmedTxnPool bounded by
+// cfg.DataCap/cfg.MaxTxns. If cfg.Datadir is set, any txns persisted by a
+// previous run are loaded back into the pool. Loaded txns are not indexed
+// or reverified against the blockchain yet -- RecordTxn/ReplaceTxn refuse
+// admission with ErrPoolNeedsLoad until LoadUnconfirmed has run, so
+// capacity enforcement and double-spend detection can never silently skip
+// the reloaded txns. Call LoadUnconfirmed once the blockchain is
+// available to index them and prune any invalidated while offline.
+func NewUnconfirmedTxnPoolWithConfig(cfg UnconfirmedTxnPoolConfig) (*UnconfirmedTxnPool, error) {
+    if cfg.PriceBump == 0 {
+        cfg.PriceBump = defaultPriceBump
+    }
+    pool := &UnconfirmedTxnPool{
+        Txns:       make(map[coin.SHA256]UnconfirmedTxn),
+        Unspent:    make(TxnUnspents),
+        cfg:        cfg,
+        evictHeap:  make(evictionHeap, 0),
+        evictIndex: make(map[coin.SHA256]*evictionItem),
+        spentIndex:  make(map[coin.SHA256]coin.SHA256),
+        addrIndex:   make(map[coin.Address]map[coin.SHA256]struct{}),
+        subscribers: make(map[int]*subscriber),
+    }
+    if cfg.Datadir == "" {
+        return pool, nil
+    }
+    records, err := loadUnconfirmedTxnsFile(cfg.Datadir)
+    if err != nil {
+        return nil, err
+    }
+    for _, ut := range records {
+        pool.Txns[ut.Hash()] = ut
+    }
+    pool.needsLoad = len(records) > 0
+    return pool, nil
+}
+
+// ErrPoolNeedsLoad is returned by RecordTxn/ReplaceTxn when the pool was
+// constructed with persisted txns that have not yet been indexed by
+// LoadUnconfirmed.
+var ErrPoolNeedsLoad = errors.New("unconfirmed txn pool has persisted txns pending LoadUnconfirmed")
+
+// LoadUnconfirmed re-verifies txns loaded from disk against the current
+// blockchain and recomputes their predicted unspents, removing any that
+// are no longer valid because their inputs were spent by blocks confirmed
+// while the node was offline. It then evicts down to cfg.MaxTxns/cfg.DataCap
+// in case the persisted set exceeds a capacity lowered since the last run.
+// Call this once, after the blockchain has been loaded and before the pool
+// accepts new txns.
+func (self *UnconfirmedTxnPool) LoadUnconfirmed(bc *coin.Blockchain, policy RelayPolicy) {
+    invalid := make([]coin.SHA256, 0)
+    for h, ut := range self.Txns {
+        t := ut.Txn
+        if err := VerifyTransactionPolicy(bc, &t, policy); err != nil {
+            invalid = append(invalid, h)
+            continue
+        }
+        if err := bc.VerifyTransaction(t); err != nil {
+            invalid = append(invalid, h)
+            continue
+        }
+        self.index(h, ut, bc)
+    }
+    self.removeTxns(bc, invalid, TxnEventRemoved)
+    self.enforceCapacity(bc)
+    self.needsLoad = false
+}
+
+// Subscribe registers a new listener for pool events and returns its
+// channel along with a CancelFunc to unsubscribe. The channel is buffered
+// per cfg.SubscriberBufferSize; if a subscriber falls behind, the oldest
+// buffered event is dropped to make room rather than blocking the pool.
+func (self *UnconfirmedTxnPool) Subscribe() (<-chan TxnEvent, CancelFunc) {
+    size := self.cfg.SubscriberBufferSize
+    if size == 0 {
+        size = defaultSubscriberBufferSize
+    }
+    sub := &subscriber{ch: make(chan TxnEvent, size)}
+
+    self.subMu.Lock()
+    id := self.nextSubscriberID
+    self.nextSubscriberID++
+    self.subscribers[id] = sub
+    self.subMu.Unlock()
+
+    cancel := func() {
+        self.subMu.Lock()
+        defer self.subMu.Unlock()
+        if sub.closed {
+            return
+        }
+        sub.closed = true
+        delete(self.subscribers, id)
+        close(sub.ch)
+    }
+    return sub.ch, cancel
+}
+
+// emit delivers ev to every subscriber registered via Subscribe. It holds
+// subMu for the duration of delivery, the same lock CancelFunc takes
+// before closing a channel, so emit can never send on a channel a
+// concurrent CancelFunc call just closed.
+func (self *UnconfirmedTxnPool) emit(ev TxnEvent) {
+    self.subMu.Lock()
+    defer self.subMu.Unlock()
+    for _, sub := range self.subscribers {
+        select {
+        case sub.ch <- ev:
+        default:
+            // Buffer full: drop the oldest event to make room, so one
+            // slow consumer can't block delivery to everyone else.
+            select {
+            case <-sub.ch:
+            default:
+            }
+            select {
+            case sub.ch <- ev:
+            default:
+            }
+        }
     }
 }
 
@@ -95,9 +449,11 @@ func (self *UnconfirmedTxnPool) createUnconfirmedTxn(bcUnsp *coin.UnspentPool,
 // Returns an error if txn is invalid, and whether the transaction already
 // existed in the pool.
 func (self *UnconfirmedTxnPool) RecordTxn(bc *coin.Blockchain,
-    t coin.Transaction, addrs map[coin.Address]byte, maxSize int,
-    burnFactor uint64) (error, bool) {
-    if err := VerifyTransaction(bc, &t, maxSize, burnFactor); err != nil {
+    t coin.Transaction, addrs map[coin.Address]byte, policy RelayPolicy) (error, bool) {
+    if self.needsLoad {
+        return ErrPoolNeedsLoad, false
+    }
+    if err := VerifyTransactionPolicy(bc, &t, policy); err != nil {
         return err, false
     }
     if err := bc.VerifyTransaction(t); err != nil {
@@ -115,14 +471,215 @@ func (self *UnconfirmedTxnPool) RecordTxn(bc *coin.Blockchain,
         return nil, true
     }
 
+    for _, in := range t.In {
+        if _, conflict := self.spentIndex[in]; conflict {
+            return errors.New("Transaction double-spends an unconfirmed transaction; use ReplaceTxn"), false
+        }
+    }
+
+    fee, err := bc.TransactionFee(&t)
+    if err != nil {
+        return err, false
+    }
+    feeRate := float64(fee) / float64(t.Size())
+    if self.atCapacity() && self.evictHeap.Len() > 0 && feeRate < self.evictHeap[0].feeRate {
+        return errors.New("Transaction fee rate too low, pool is full"), false
+    }
+
     // Add txn to index
-    self.Txns[h] = self.createUnconfirmedTxn(&bc.Unspent, t, addrs)
-    // Add predicted unspents
-    self.Unspent[h] = coin.CreateUnspents(bc.Head().Head, t)
+    utxn := self.createUnconfirmedTxn(&bc.Unspent, t, addrs)
+    self.Txns[h] = utxn
+    self.index(h, utxn, bc)
+    self.emit(TxnEvent{Kind: TxnEventAdded, Hash: h, Txn: t})
+
+    self.enforceCapacity(bc)
+
+    if err := self.persist(); err != nil {
+        return err, false
+    }
 
     return nil, false
 }
 
+// ReplaceTxn attempts to add t to the pool even though it double-spends an
+// existing pool txn's input. The replacement is only allowed if t's
+// fee-per-byte exceeds every incumbent it conflicts with by at least
+// cfg.PriceBump percent; otherwise ErrReplacementUnderpriced is returned
+// and the pool is left unchanged. If t does not conflict with anything,
+// this behaves exactly like RecordTxn.
+func (self *UnconfirmedTxnPool) ReplaceTxn(bc *coin.Blockchain, t coin.Transaction,
+    addrs map[coin.Address]byte, policy RelayPolicy) (error, bool) {
+    if self.needsLoad {
+        return ErrPoolNeedsLoad, false
+    }
+    if err := VerifyTransactionPolicy(bc, &t, policy); err != nil {
+        return err, false
+    }
+    if err := bc.VerifyTransaction(t); err != nil {
+        return err, false
+    }
+
+    h := t.Hash()
+    if _, ok := self.Txns[h]; ok {
+        return self.RecordTxn(bc, t, addrs, policy)
+    }
+
+    fee, err := bc.TransactionFee(&t)
+    if err != nil {
+        return err, false
+    }
+    feeRate := float64(fee) / float64(t.Size())
+
+    incumbents := make(map[coin.SHA256]struct{})
+    for _, in := range t.In {
+        if owner, ok := self.spentIndex[in]; ok {
+            incumbents[owner] = struct{}{}
+        }
+    }
+
+    for incumbent := range incumbents {
+        ut := self.Txns[incumbent]
+        incumbentRate := ut.feeRate(bc)
+        if !meetsPriceBump(feeRate, incumbentRate, self.cfg.PriceBump) {
+            return ErrReplacementUnderpriced, false
+        }
+    }
+
+    for incumbent := range incumbents {
+        self.removeTxnCascade(bc, incumbent, TxnEventReplaced)
+    }
+
+    return self.RecordTxn(bc, t, addrs, policy)
+}
+
+// index records t's predicted unspents, byte size, eviction ranking and
+// spent inputs. Shared by RecordTxn and LoadUnconfirmed so a freshly
+// admitted txn and one reloaded from disk are tracked identically.
+func (self *UnconfirmedTxnPool) index(h coin.SHA256, ut UnconfirmedTxn, bc *coin.Blockchain) {
+    predicted := coin.CreateUnspents(bc.Head().Head, ut.Txn)
+    self.Unspent[h] = predicted
+    self.size += uint64(ut.Txn.Size())
+    self.pushEviction(h, ut, bc)
+    for _, in := range ut.Txn.In {
+        self.spentIndex[in] = h
+        if ux, ok := bc.Unspent.Get(in); ok {
+            self.addAddrIndex(ux.Body.Address, h)
+        }
+    }
+    for _, ux := range predicted {
+        self.addAddrIndex(ux.Body.Address, h)
+    }
+}
+
+func (self *UnconfirmedTxnPool) addAddrIndex(a coin.Address, h coin.SHA256) {
+    hashes, ok := self.addrIndex[a]
+    if !ok {
+        hashes = make(map[coin.SHA256]struct{})
+        self.addrIndex[a] = hashes
+    }
+    hashes[h] = struct{}{}
+}
+
+func (self *UnconfirmedTxnPool) removeAddrIndex(a coin.Address, h coin.SHA256) {
+    hashes, ok := self.addrIndex[a]
+    if !ok {
+        return
+    }
+    delete(hashes, h)
+    if len(hashes) == 0 {
+        delete(self.addrIndex, a)
+    }
+}
+
+// unindexAddresses removes h from addrIndex for every address it touches:
+// the owners of its spent inputs and the recipients of its predicted
+// outputs. predicted must be captured before self.Unspent[h] is deleted.
+func (self *UnconfirmedTxnPool) unindexAddresses(bc *coin.Blockchain, h coin.SHA256,
+    ut UnconfirmedTxn, predicted coin.UxArray) {
+    for _, ux := range predicted {
+        self.removeAddrIndex(ux.Body.Address, h)
+    }
+    for _, in := range ut.Txn.In {
+        if ux, ok := bc.Unspent.Get(in); ok {
+            self.removeAddrIndex(ux.Body.Address, h)
+        } else {
+            // The input's source UxOut is no longer in bc.Unspent, so its
+            // owner can't be looked up: sweep every address for h instead.
+            for a := range self.addrIndex {
+                self.removeAddrIndex(a, h)
+            }
+            return
+        }
+    }
+}
+
+// TxnsForAddress returns all pool txns that touch address a, either by
+// spending an input owned by a or by paying a predicted output to a.
+func (self *UnconfirmedTxnPool) TxnsForAddress(a coin.Address) []UnconfirmedTxn {
+    hashes, ok := self.addrIndex[a]
+    if !ok {
+        return nil
+    }
+    txns := make([]UnconfirmedTxn, 0, len(hashes))
+    for h := range hashes {
+        txns = append(txns, self.Txns[h])
+    }
+    return txns
+}
+
+// enforceCapacity evicts the lowest fee-per-byte txns until the pool fits
+// within cfg.MaxTxns and cfg.DataCap.
+func (self *UnconfirmedTxnPool) enforceCapacity(bc *coin.Blockchain) {
+    for self.overCapacity() && self.evictHeap.Len() > 0 {
+        victim := self.evictHeap[0]
+        self.removeTxnCascade(bc, victim.hash, TxnEventEvicted)
+    }
+}
+
+func (self *UnconfirmedTxnPool) overCapacity() bool {
+    if self.cfg.MaxTxns > 0 && len(self.Txns) > self.cfg.MaxTxns {
+        return true
+    }
+    if self.cfg.DataCap > 0 && self.size > self.cfg.DataCap {
+        return true
+    }
+    return false
+}
+
+// atCapacity reports whether the pool is already full enough that
+// admitting one more txn would require an eviction. Used to decide
+// whether a new txn's fee-rate must clear the current lowest-ranked txn
+// in evictHeap before being admitted at all.
+func (self *UnconfirmedTxnPool) atCapacity() bool {
+    if self.cfg.MaxTxns > 0 && len(self.Txns) >= self.cfg.MaxTxns {
+        return true
+    }
+    if self.cfg.DataCap > 0 && self.size >= self.cfg.DataCap {
+        return true
+    }
+    return false
+}
+
+func (self *UnconfirmedTxnPool) pushEviction(h coin.SHA256, ut UnconfirmedTxn,
+    bc *coin.Blockchain) {
+    item := &evictionItem{
+        hash:     h,
+        feeRate:  ut.feeRate(bc),
+        received: ut.Received,
+    }
+    self.evictIndex[h] = item
+    heap.Push(&self.evictHeap, item)
+}
+
+func (self *UnconfirmedTxnPool) popEviction(h coin.SHA256) {
+    item, ok := self.evictIndex[h]
+    if !ok {
+        return
+    }
+    heap.Remove(&self.evictHeap, item.index)
+    delete(self.evictIndex, h)
+}
+
 // Returns underlying coin.Transactions
 func (self *UnconfirmedTxnPool) RawTxns() coin.Transactions {
     txns := make(coin.Transactions, len(self.Txns))
@@ -136,18 +693,51 @@ func (self *UnconfirmedTxnPool) RawTxns() coin.Transactions {
 
 // Remove a single txn by hash
 func (self *UnconfirmedTxnPool) removeTxn(bc *coin.Blockchain,
-    txHash coin.SHA256) {
+    txHash coin.SHA256, kind TxnEventKind) {
+    ut, ok := self.Txns[txHash]
+    if ok {
+        // Only txns that went through index() were counted in size; txns
+        // reloaded from disk but not yet indexed (or pruned as invalid by
+        // LoadUnconfirmed) have no Unspent entry.
+        if _, indexed := self.Unspent[txHash]; indexed {
+            self.size -= uint64(ut.Txn.Size())
+        }
+        for _, in := range ut.Txn.In {
+            if owner, ok := self.spentIndex[in]; ok && owner == txHash {
+                delete(self.spentIndex, in)
+            }
+        }
+        self.unindexAddresses(bc, txHash, ut, self.Unspent[txHash])
+    }
     delete(self.Txns, txHash)
     delete(self.Unspent, txHash)
+    self.popEviction(txHash)
+    if ok {
+        self.emit(TxnEvent{Kind: kind, Hash: txHash, Txn: ut.Txn})
+    }
+}
+
+// removeTxnCascade removes txHash from the pool along with any pool txns
+// that spend one of its predicted outputs (its redeemers), recursively.
+// Used when a txn is evicted or replaced, since its predicted outputs
+// cease to exist and anything spending them becomes invalid.
+func (self *UnconfirmedTxnPool) removeTxnCascade(bc *coin.Blockchain, txHash coin.SHA256,
+    kind TxnEventKind) {
+    predicted := self.Unspent[txHash]
+    self.removeTxn(bc, txHash, kind)
+    for _, ux := range predicted {
+        if redeemer, ok := self.spentIndex[ux.Hash()]; ok {
+            self.removeTxnCascade(bc, redeemer, kind)
+        }
+    }
 }
 
 // Removes multiple txns at once. Slightly more efficient than a series of
 // single RemoveTxns.  Hashes is an array of Transaction hashes.
 func (self *UnconfirmedTxnPool) removeTxns(bc *coin.Blockchain,
-    hashes []coin.SHA256) {
+    hashes []coin.SHA256, kind TxnEventKind) {
     for i, _ := range hashes {
-        delete(self.Txns, hashes[i])
-        delete(self.Unspent, hashes[i])
+        self.removeTxn(bc, hashes[i], kind)
     }
 }
 
@@ -158,7 +748,7 @@ func (self *UnconfirmedTxnPool) RemoveTransactions(bc *coin.Blockchain,
     for i, _ := range txns {
         toRemove[i] = txns[i].Hash()
     }
-    self.removeTxns(bc, toRemove)
+    self.removeTxns(bc, toRemove, TxnEventConfirmed)
 }
 
 // Checks all unconfirmed txns against the blockchain. maxAge is how long
@@ -180,7 +770,7 @@ func (self *UnconfirmedTxnPool) Refresh(bc *coin.Blockchain,
             }
         }
     }
-    self.removeTxns(bc, toRemove)
+    self.removeTxns(bc, toRemove, TxnEventRemoved)
 }
 
 // Returns txn hashes with known ones removed
@@ -206,17 +796,17 @@ func (self *UnconfirmedTxnPool) GetKnown(txns []coin.SHA256) coin.Transactions {
 }
 
 // Returns all unconfirmed coin.UxOut spends for addresses
-// Looks at all inputs for unconfirmed txns, gets their source UxOut from the
-// blockchain's unspent pool, and returns as coin.AddressUxOuts
-// TODO -- optimize or cache
+// Uses addrIndex to look up only the pool txns that touch each address,
+// instead of scanning every unconfirmed txn's inputs.
 func (self *UnconfirmedTxnPool) SpendsForAddresses(bcUnspent *coin.UnspentPool,
     a map[coin.Address]byte) coin.AddressUxOuts {
     auxs := make(coin.AddressUxOuts, len(a))
-    for _, utx := range self.Txns {
-        for _, h := range utx.Txn.In {
-            if ux, ok := bcUnspent.Get(h); ok {
-                if _, ok := a[ux.Body.Address]; ok {
-                    auxs[ux.Body.Address] = append(auxs[ux.Body.Address], ux)
+    for addr := range a {
+        for h := range self.addrIndex[addr] {
+            utx := self.Txns[h]
+            for _, in := range utx.Txn.In {
+                if ux, ok := bcUnspent.Get(in); ok && ux.Body.Address == addr {
+                    auxs[addr] = append(auxs[addr], ux)
                 }
             }
         }
@@ -230,3 +820,53 @@ func (self *UnconfirmedTxnPool) SpendsForAddress(bcUnspent *coin.UnspentPool,
     auxs := self.SpendsForAddresses(bcUnspent, ma)
     return auxs[a]
 }
+
+// persist writes the current pool contents to cfg.Datadir. It is a no-op
+// when the pool is memory-only.
+func (self *UnconfirmedTxnPool) persist() error {
+    if self.cfg.Datadir == "" {
+        return nil
+    }
+    records := make([]UnconfirmedTxn, 0, len(self.Txns))
+    for _, t := range self.Txns {
+        records = append(records, t)
+    }
+    return writeUnconfirmedTxnsFile(self.cfg.Datadir, records)
+}
+
+func writeUnconfirmedTxnsFile(datadir string, records []UnconfirmedTxn) error {
+    if err := os.MkdirAll(datadir, 0750); err != nil {
+        return err
+    }
+    path := filepath.Join(datadir, unconfirmedTxnsFilename)
+    tmp := path + ".tmp"
+    f, err := os.Create(tmp)
+    if err != nil {
+        return err
+    }
+    if err := gob.NewEncoder(f).Encode(records); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+func loadUnconfirmedTxnsFile(datadir string) ([]UnconfirmedTxn, error) {
+    path := filepath.Join(datadir, unconfirmedTxnsFilename)
+    f, err := os.Open(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
+        }
+        return nil, err
+    }
+    defer f.Close()
+    var records []UnconfirmedTxn
+    if err := gob.NewDecoder(f).Decode(&records); err != nil {
+        return nil, err
+    }
+    return records, nil
+}
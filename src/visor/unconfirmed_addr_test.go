@@ -0,0 +1,102 @@
+package visor
+
+import (
+    "reflect"
+    "testing"
+
+    "github.com/skycoin/skycoin/src/coin"
+)
+
+func newTestPool() *UnconfirmedTxnPool {
+    return &UnconfirmedTxnPool{
+        Txns:        make(map[coin.SHA256]UnconfirmedTxn),
+        Unspent:     make(TxnUnspents),
+        evictHeap:   make(evictionHeap, 0),
+        evictIndex:  make(map[coin.SHA256]*evictionItem),
+        spentIndex:  make(map[coin.SHA256]coin.SHA256),
+        addrIndex:   make(map[coin.Address]map[coin.SHA256]struct{}),
+        subscribers: make(map[int]*subscriber),
+    }
+}
+
+// TestAddrIndexAddRemove drives addAddrIndex/removeAddrIndex/TxnsForAddress
+// directly -- they're reachable from an in-package test -- since building a
+// real *coin.Blockchain to exercise them via index()/RecordTxn would
+// require the coin package, which this source snapshot doesn't include.
+func TestAddrIndexAddRemove(t *testing.T) {
+    pool := newTestPool()
+    a := coin.Address{0: 1}
+    h1 := coin.SHA256{1}
+    h2 := coin.SHA256{2}
+    pool.Txns[h1] = UnconfirmedTxn{}
+    pool.Txns[h2] = UnconfirmedTxn{}
+
+    if got := pool.TxnsForAddress(a); got != nil {
+        t.Fatalf("TxnsForAddress on an untouched address = %v, want nil", got)
+    }
+
+    pool.addAddrIndex(a, h1)
+    pool.addAddrIndex(a, h2)
+    got := pool.TxnsForAddress(a)
+    if len(got) != 2 {
+        t.Fatalf("TxnsForAddress after two adds = %d entries, want 2", len(got))
+    }
+
+    pool.removeAddrIndex(a, h1)
+    got = pool.TxnsForAddress(a)
+    if len(got) != 1 {
+        t.Fatalf("TxnsForAddress after removing one of two = %d entries, want 1", len(got))
+    }
+
+    pool.removeAddrIndex(a, h2)
+    if got := pool.TxnsForAddress(a); got != nil {
+        t.Fatalf("TxnsForAddress after removing the last entry = %v, want nil", got)
+    }
+    if _, ok := pool.addrIndex[a]; ok {
+        t.Fatal("addrIndex should drop the address entirely once its hash set is empty")
+    }
+}
+
+// TestAddrIndexIndependentAddresses checks that adding/removing hashes for
+// one address doesn't disturb another address's entry.
+func TestAddrIndexIndependentAddresses(t *testing.T) {
+    pool := newTestPool()
+    a := coin.Address{0: 1}
+    b := coin.Address{0: 2}
+    h := coin.SHA256{1}
+    pool.Txns[h] = UnconfirmedTxn{}
+
+    pool.addAddrIndex(a, h)
+    pool.addAddrIndex(b, h)
+    pool.removeAddrIndex(a, h)
+
+    if got := pool.TxnsForAddress(a); got != nil {
+        t.Fatalf("TxnsForAddress(a) after removal = %v, want nil", got)
+    }
+    wantB := []UnconfirmedTxn{{}}
+    if got := pool.TxnsForAddress(b); !reflect.DeepEqual(got, wantB) {
+        t.Fatalf("TxnsForAddress(b) = %+v, want %+v", got, wantB)
+    }
+}
+
+// TestSpendsForAddressesEmptyInputs drives SpendsForAddresses via addrIndex
+// using a pool txn with no inputs, so bcUnspent is never dereferenced --
+// exercising the real ux lookup needs a *coin.UnspentPool fixture, which
+// this source snapshot's missing coin package can't provide.
+func TestSpendsForAddressesEmptyInputs(t *testing.T) {
+    pool := newTestPool()
+    a := coin.Address{0: 1}
+    h := coin.SHA256{1}
+    pool.Txns[h] = UnconfirmedTxn{Txn: coin.Transaction{}}
+    pool.addAddrIndex(a, h)
+
+    got := pool.SpendsForAddresses(nil, map[coin.Address]byte{a: 1})
+    if len(got[a]) != 0 {
+        t.Fatalf("SpendsForAddresses for a txn with no inputs = %v, want no spends", got[a])
+    }
+
+    other := coin.Address{0: 2}
+    if got := pool.SpendsForAddresses(nil, map[coin.Address]byte{other: 1}); len(got[other]) != 0 {
+        t.Fatalf("SpendsForAddresses for an address not in addrIndex = %v, want no spends", got[other])
+    }
+}
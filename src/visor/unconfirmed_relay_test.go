@@ -0,0 +1,27 @@
+package visor
+
+import "testing"
+
+func TestMinRelayFee(t *testing.T) {
+    cases := []struct {
+        name   string
+        size   int
+        policy RelayPolicy
+        want   uint64
+    }{
+        {"disabled", 5000, RelayPolicy{MinRelayFeePerKB: 0}, 0},
+        {"under 1KB", 999, RelayPolicy{MinRelayFeePerKB: 100}, 100},
+        {"exactly 1KB", 1000, RelayPolicy{MinRelayFeePerKB: 100}, 200},
+        {"just over 1KB", 1001, RelayPolicy{MinRelayFeePerKB: 100}, 200},
+        {"exactly 2KB", 2000, RelayPolicy{MinRelayFeePerKB: 100}, 300},
+        {"just under free relay threshold", 499, RelayPolicy{MinRelayFeePerKB: 100, FreeRelaySize: 500}, 0},
+        {"at free relay threshold", 500, RelayPolicy{MinRelayFeePerKB: 100, FreeRelaySize: 500}, 100},
+        {"just over free relay threshold", 501, RelayPolicy{MinRelayFeePerKB: 100, FreeRelaySize: 500}, 100},
+    }
+    for _, c := range cases {
+        got := minRelayFee(c.size, c.policy)
+        if got != c.want {
+            t.Errorf("%s: minRelayFee(%d, %+v) = %d, want %d", c.name, c.size, c.policy, got, c.want)
+        }
+    }
+}
@@ -0,0 +1,72 @@
+package visor
+
+import (
+    "container/heap"
+    "testing"
+
+    "github.com/skycoin/skycoin/src/coin"
+)
+
+// TestMeetsPriceBump covers the price-bump threshold ReplaceTxn enforces
+// before letting a conflicting txn evict an incumbent.
+//
+// ReplaceTxn's own conflict detection -- gathering incumbents from
+// spentIndex and computing fee rates via bc.TransactionFee -- drives its
+// decisions off a real *coin.Blockchain, which this source snapshot does
+// not include, so that part of ReplaceTxn can't be driven end-to-end here.
+// meetsPriceBump is the pure threshold check it was factored to use
+// specifically so this boundary logic stays testable independent of that;
+// TestRemoveTxnCascadeRemovesRedeemers below covers the other piece
+// ReplaceTxn relies on, cascade removal of an incumbent's redeemers.
+func TestMeetsPriceBump(t *testing.T) {
+    cases := []struct {
+        newRate, incumbentRate float64
+        priceBumpPercent        uint64
+        want                    bool
+    }{
+        {11, 10, 10, true},   // exactly 10% higher: meets the threshold
+        {10.99, 10, 10, false}, // just under 10% higher: rejected
+        {10, 10, 0, true},    // 0% bump required: equal rate is enough
+        {9, 10, 0, false},    // 0% bump required, but still strictly lower
+    }
+    for _, c := range cases {
+        got := meetsPriceBump(c.newRate, c.incumbentRate, c.priceBumpPercent)
+        if got != c.want {
+            t.Errorf("meetsPriceBump(%v, %v, %v) = %v, want %v",
+                c.newRate, c.incumbentRate, c.priceBumpPercent, got, c.want)
+        }
+    }
+}
+
+// TestRemoveTxnCascadeRemovesRedeemers drives removeTxnCascade directly:
+// the incumbent and its redeemer both carry no inputs, so bc is never
+// dereferenced, letting ReplaceTxn's replacement mechanism run without a
+// *coin.Blockchain fixture.
+func TestRemoveTxnCascadeRemovesRedeemers(t *testing.T) {
+    pool := newTestPool()
+
+    incumbent := coin.SHA256{1}
+    redeemer := coin.SHA256{2}
+    var ux coin.UxOut
+    spent := ux.Hash()
+
+    pool.Txns[incumbent] = UnconfirmedTxn{}
+    pool.Txns[redeemer] = UnconfirmedTxn{}
+    pool.Unspent[incumbent] = coin.UxArray{ux}
+    pool.spentIndex[spent] = redeemer
+    incumbentItem := &evictionItem{hash: incumbent}
+    redeemerItem := &evictionItem{hash: redeemer}
+    pool.evictIndex[incumbent] = incumbentItem
+    pool.evictIndex[redeemer] = redeemerItem
+    heap.Push(&pool.evictHeap, incumbentItem)
+    heap.Push(&pool.evictHeap, redeemerItem)
+
+    pool.removeTxnCascade(nil, incumbent, TxnEventReplaced)
+
+    if _, ok := pool.Txns[incumbent]; ok {
+        t.Fatal("removeTxnCascade should remove the incumbent itself")
+    }
+    if _, ok := pool.Txns[redeemer]; ok {
+        t.Fatal("removeTxnCascade should cascade-remove a txn that spends the incumbent's predicted output")
+    }
+}
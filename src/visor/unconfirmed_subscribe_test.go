@@ -0,0 +1,97 @@
+package visor
+
+import (
+    "testing"
+
+    "github.com/skycoin/skycoin/src/coin"
+)
+
+func TestSubscribeDeliversEvents(t *testing.T) {
+    pool := NewUnconfirmedTxnPool()
+    ch, cancel := pool.Subscribe()
+    defer cancel()
+
+    ev := TxnEvent{Kind: TxnEventAdded, Hash: coin.SHA256{1}}
+    pool.emit(ev)
+
+    select {
+    case got := <-ch:
+        if got != ev {
+            t.Fatalf("received %+v, want %+v", got, ev)
+        }
+    default:
+        t.Fatal("subscriber channel had no event buffered after emit")
+    }
+}
+
+func TestSubscribeMultipleSubscribersAllReceive(t *testing.T) {
+    pool := NewUnconfirmedTxnPool()
+    ch1, cancel1 := pool.Subscribe()
+    defer cancel1()
+    ch2, cancel2 := pool.Subscribe()
+    defer cancel2()
+
+    ev := TxnEvent{Kind: TxnEventConfirmed, Hash: coin.SHA256{9}}
+    pool.emit(ev)
+
+    for i, ch := range []<-chan TxnEvent{ch1, ch2} {
+        select {
+        case got := <-ch:
+            if got != ev {
+                t.Fatalf("subscriber %d received %+v, want %+v", i, got, ev)
+            }
+        default:
+            t.Fatalf("subscriber %d had no event buffered after emit", i)
+        }
+    }
+}
+
+// TestSubscribeDropOldest checks the backpressure policy documented on
+// emit: once a subscriber's buffer is full, the oldest buffered event is
+// dropped to make room for the new one rather than blocking delivery.
+func TestSubscribeDropOldest(t *testing.T) {
+    pool, err := NewUnconfirmedTxnPoolWithConfig(UnconfirmedTxnPoolConfig{SubscriberBufferSize: 2})
+    if err != nil {
+        t.Fatalf("NewUnconfirmedTxnPoolWithConfig: %v", err)
+    }
+    ch, cancel := pool.Subscribe()
+    defer cancel()
+
+    pool.emit(TxnEvent{Kind: TxnEventAdded, Hash: coin.SHA256{1}})
+    pool.emit(TxnEvent{Kind: TxnEventAdded, Hash: coin.SHA256{2}})
+    pool.emit(TxnEvent{Kind: TxnEventAdded, Hash: coin.SHA256{3}})
+
+    want := []coin.SHA256{{2}, {3}}
+    for i, w := range want {
+        select {
+        case got := <-ch:
+            if got.Hash != w {
+                t.Fatalf("event %d: hash %v, want %v (oldest event should have been dropped)", i, got.Hash, w)
+            }
+        default:
+            t.Fatalf("event %d: channel empty, want buffered event with hash %v", i, w)
+        }
+    }
+    select {
+    case got := <-ch:
+        t.Fatalf("expected only %d buffered events, got an extra one: %+v", len(want), got)
+    default:
+    }
+}
+
+func TestSubscribeCancelClosesChannelAndIsIdempotent(t *testing.T) {
+    pool := NewUnconfirmedTxnPool()
+    ch, cancel := pool.Subscribe()
+
+    cancel()
+    if _, ok := <-ch; ok {
+        t.Fatal("channel should be closed after cancel")
+    }
+
+    // Must not panic.
+    cancel()
+
+    // A cancelled subscriber must not receive further events, and emit
+    // must not panic sending to a closed/removed channel.
+    pool.emit(TxnEvent{Kind: TxnEventAdded, Hash: coin.SHA256{1}})
+}
@@ -0,0 +1,197 @@
+package visor
+
+import (
+    "container/heap"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/skycoin/skycoin/src/coin"
+)
+
+func TestEvictionHeapOrdering(t *testing.T) {
+    now := time.Now()
+    h := make(evictionHeap, 0)
+
+    heap.Push(&h, &evictionItem{hash: coin.SHA256{1}, feeRate: 3.0, received: now})
+    heap.Push(&h, &evictionItem{hash: coin.SHA256{2}, feeRate: 1.0, received: now.Add(time.Second)})
+    heap.Push(&h, &evictionItem{hash: coin.SHA256{3}, feeRate: 1.0, received: now})
+    heap.Push(&h, &evictionItem{hash: coin.SHA256{4}, feeRate: 2.0, received: now})
+
+    // Lowest feeRate first; among equal feeRate, oldest Received first.
+    want := []coin.SHA256{{3}, {2}, {4}, {1}}
+    for i, w := range want {
+        got := heap.Pop(&h).(*evictionItem)
+        if got.hash != w {
+            t.Fatalf("pop %d: got hash %v, want %v", i, got.hash, w)
+        }
+    }
+}
+
+// TestPersistRoundTrip exercises writeUnconfirmedTxnsFile and
+// loadUnconfirmedTxnsFile directly, the way persist() and
+// NewUnconfirmedTxnPoolWithConfig use them, without needing a
+// *coin.Blockchain.
+func TestPersistRoundTrip(t *testing.T) {
+    datadir := t.TempDir()
+
+    want := []UnconfirmedTxn{
+        {Txn: coin.Transaction{}, Received: time.Unix(1, 0).UTC(), Checked: time.Unix(2, 0).UTC()},
+    }
+    if err := writeUnconfirmedTxnsFile(datadir, want); err != nil {
+        t.Fatalf("writeUnconfirmedTxnsFile: %v", err)
+    }
+
+    if got, err := loadUnconfirmedTxnsFile(filepath.Join(datadir, "missing")); err != nil || got != nil {
+        t.Fatalf("loadUnconfirmedTxnsFile on a datadir with no persisted file = (%v, %v), want (nil, nil)", got, err)
+    }
+
+    got, err := loadUnconfirmedTxnsFile(datadir)
+    if err != nil {
+        t.Fatalf("loadUnconfirmedTxnsFile: %v", err)
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Fatalf("loadUnconfirmedTxnsFile round-trip = %+v, want %+v", got, want)
+    }
+}
+
+// TestNewUnconfirmedTxnPoolWithConfigNeedsLoad checks that loading persisted
+// txns at construction time sets needsLoad, that RecordTxn/ReplaceTxn then
+// refuse admission without touching bc, and that LoadUnconfirmed clears the
+// flag once the reloaded set has been indexed.
+func TestNewUnconfirmedTxnPoolWithConfigNeedsLoad(t *testing.T) {
+    datadir := t.TempDir()
+    persisted := []UnconfirmedTxn{{Txn: coin.Transaction{}, Received: time.Unix(1, 0).UTC()}}
+    if err := writeUnconfirmedTxnsFile(datadir, persisted); err != nil {
+        t.Fatalf("writeUnconfirmedTxnsFile: %v", err)
+    }
+
+    pool, err := NewUnconfirmedTxnPoolWithConfig(UnconfirmedTxnPoolConfig{Datadir: datadir})
+    if err != nil {
+        t.Fatalf("NewUnconfirmedTxnPoolWithConfig: %v", err)
+    }
+    if !pool.needsLoad {
+        t.Fatal("pool with persisted txns should have needsLoad set")
+    }
+    if len(pool.Txns) != 1 {
+        t.Fatalf("pool.Txns = %d entries, want 1", len(pool.Txns))
+    }
+
+    // bc is never dereferenced: the needsLoad guard must fire first.
+    if err, existed := pool.RecordTxn(nil, coin.Transaction{}, nil, RelayPolicy{}); err != ErrPoolNeedsLoad || existed {
+        t.Fatalf("RecordTxn on a pool needing load = (%v, %v), want (%v, false)", err, existed, ErrPoolNeedsLoad)
+    }
+    if err, existed := pool.ReplaceTxn(nil, coin.Transaction{}, nil, RelayPolicy{}); err != ErrPoolNeedsLoad || existed {
+        t.Fatalf("ReplaceTxn on a pool needing load = (%v, %v), want (%v, false)", err, existed, ErrPoolNeedsLoad)
+    }
+
+    // Once the reloaded set is indexed, needsLoad is cleared. An empty
+    // Txns map lets LoadUnconfirmed run to completion without
+    // dereferencing bc.
+    empty := &UnconfirmedTxnPool{
+        Txns:        make(map[coin.SHA256]UnconfirmedTxn),
+        Unspent:     make(TxnUnspents),
+        evictHeap:   make(evictionHeap, 0),
+        evictIndex:  make(map[coin.SHA256]*evictionItem),
+        spentIndex:  make(map[coin.SHA256]coin.SHA256),
+        addrIndex:   make(map[coin.Address]map[coin.SHA256]struct{}),
+        subscribers: make(map[int]*subscriber),
+        needsLoad:   true,
+    }
+    empty.LoadUnconfirmed(nil, RelayPolicy{})
+    if empty.needsLoad {
+        t.Fatal("LoadUnconfirmed should clear needsLoad")
+    }
+}
+
+// TestRawTxnsSurfacesUnindexedReloadedTxns confirms a reloaded txn is not
+// lost while needsLoad is set -- only capacity/double-spend enforcement is
+// deferred until LoadUnconfirmed runs, not visibility of the raw data.
+func TestRawTxnsSurfacesUnindexedReloadedTxns(t *testing.T) {
+    datadir := t.TempDir()
+    persisted := []UnconfirmedTxn{{Txn: coin.Transaction{In: []coin.SHA256{{9}}}, Received: time.Unix(1, 0).UTC()}}
+    if err := writeUnconfirmedTxnsFile(datadir, persisted); err != nil {
+        t.Fatalf("writeUnconfirmedTxnsFile: %v", err)
+    }
+
+    pool, err := NewUnconfirmedTxnPoolWithConfig(UnconfirmedTxnPoolConfig{Datadir: datadir})
+    if err != nil {
+        t.Fatalf("NewUnconfirmedTxnPoolWithConfig: %v", err)
+    }
+    if !pool.needsLoad {
+        t.Fatal("pool with persisted txns should have needsLoad set")
+    }
+
+    raw := pool.RawTxns()
+    if len(raw) != 1 || len(raw[0].In) != 1 || raw[0].In[0] != (coin.SHA256{9}) {
+        t.Fatalf("RawTxns() = %+v, want the one reloaded txn to remain visible while needsLoad is set", raw)
+    }
+}
+
+// TestEnforceCapacityEvictsLowestFeeRate drives enforceCapacity/removeTxnCascade
+// directly: both txns carry no inputs, so bc is never dereferenced, letting
+// the eviction path run without a *coin.Blockchain fixture.
+func TestEnforceCapacityEvictsLowestFeeRate(t *testing.T) {
+    pool := newTestPool()
+    pool.cfg.MaxTxns = 1
+
+    low := coin.SHA256{1}
+    high := coin.SHA256{2}
+    pool.Txns[low] = UnconfirmedTxn{}
+    pool.Txns[high] = UnconfirmedTxn{}
+    lowItem := &evictionItem{hash: low, feeRate: 1}
+    highItem := &evictionItem{hash: high, feeRate: 5}
+    pool.evictIndex[low] = lowItem
+    pool.evictIndex[high] = highItem
+    heap.Push(&pool.evictHeap, lowItem)
+    heap.Push(&pool.evictHeap, highItem)
+
+    pool.enforceCapacity(nil)
+
+    if _, ok := pool.Txns[low]; ok {
+        t.Fatal("lowest fee-rate txn should have been evicted to satisfy MaxTxns")
+    }
+    if _, ok := pool.Txns[high]; !ok {
+        t.Fatal("higher fee-rate txn should have survived eviction")
+    }
+    if len(pool.Txns) != 1 {
+        t.Fatalf("pool.Txns has %d entries after enforceCapacity, want 1", len(pool.Txns))
+    }
+}
+
+// TestRemoveTxnUpdatesBookkeeping checks that removeTxn's side effects --
+// dropping the txn from Txns/Unspent/evictIndex and notifying subscribers
+// -- all happen together.
+func TestRemoveTxnUpdatesBookkeeping(t *testing.T) {
+    pool := newTestPool()
+    h := coin.SHA256{1}
+    pool.Txns[h] = UnconfirmedTxn{Txn: coin.Transaction{}}
+    pool.Unspent[h] = coin.UxArray{}
+    item := &evictionItem{hash: h}
+    pool.evictIndex[h] = item
+    heap.Push(&pool.evictHeap, item)
+
+    ch, cancel := pool.Subscribe()
+    defer cancel()
+
+    pool.removeTxn(nil, h, TxnEventRemoved)
+
+    if _, ok := pool.Txns[h]; ok {
+        t.Fatal("removeTxn should delete the txn from Txns")
+    }
+    if _, ok := pool.Unspent[h]; ok {
+        t.Fatal("removeTxn should delete the txn's predicted unspents")
+    }
+    if _, ok := pool.evictIndex[h]; ok {
+        t.Fatal("removeTxn should remove the txn from the eviction heap")
+    }
+    select {
+    case ev := <-ch:
+        if ev.Kind != TxnEventRemoved || ev.Hash != h {
+            t.Fatalf("emitted event = %+v, want Kind=%v Hash=%v", ev, TxnEventRemoved, h)
+        }
+    default:
+        t.Fatal("removeTxn should emit a TxnEvent for subscribers")
+    }
+}